@@ -2,21 +2,29 @@ package traefik_auth_converter
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 type TokenSource string
 type AuthType string
 
 const (
-	password  TokenSource = "password"
-	username  TokenSource = "username"
-	unchanged TokenSource = "full"
-	decoded   TokenSource = "decoded"
-	combined  TokenSource = "combined"
+	password   TokenSource = "password"
+	username   TokenSource = "username"
+	unchanged  TokenSource = "full"
+	decoded    TokenSource = "decoded"
+	combined   TokenSource = "combined"
+	jwtClaim   TokenSource = "jwt_claim"
+	jwtSubject TokenSource = "jwt_subject"
+	basicPair  TokenSource = "basic_pair"
 )
 
 const (
@@ -35,39 +43,137 @@ type Config struct {
 	encodeToken bool        `yaml:"encodeToken"`
 	sourceType  AuthType    `yaml:"sourceType"`
 	targetType  AuthType    `yaml:"targetType"`
+
+	// forwardAddress, when set, switches the middleware to forward-auth mode:
+	// the incoming credentials are exchanged against this endpoint for a token
+	// instead of being rewritten in place.
+	forwardAddress            string        `yaml:"forwardAddress"`
+	forwardInsecureSkipVerify bool          `yaml:"forwardInsecureSkipVerify"`
+	forwardResponseHeader     string        `yaml:"forwardResponseHeader"`
+	forwardResponseJSONPath   string        `yaml:"forwardResponseJSONPath"`
+	forwardForwardHeaders     bool          `yaml:"forwardForwardHeaders"`
+	forwardTimeout            time.Duration `yaml:"forwardTimeout"`
+	forwardCacheTTL           time.Duration `yaml:"forwardCacheTTL"`
+
+	// claimPath is the dotted path (e.g. "preferred_username" or
+	// "resource_access.myapp.roles.0") read out of the JWT payload when
+	// tokenSource is jwtClaim. It is ignored for jwtSubject, which always
+	// reads the standard "sub" claim.
+	claimPath string `yaml:"claimPath"`
+
+	// jwksURL, when set, enables signature verification of the JWT before
+	// its claims are trusted. Fetched keys are cached in the middleware.
+	jwksURL      string        `yaml:"jwksURL"`
+	jwksCacheTTL time.Duration `yaml:"jwksCacheTTL"`
+
+	// digestUpstream overrides the address used to preflight a Digest
+	// WWW-Authenticate challenge when targetType is digest. When empty,
+	// the incoming request's own scheme/host/path is used.
+	digestUpstream          string        `yaml:"digestUpstream"`
+	digestChallengeCacheTTL time.Duration `yaml:"digestChallengeCacheTTL"`
+
+	// Rules, when non-empty, switches the middleware to rule-pool mode: each
+	// incoming request is matched against the rules in order and converted
+	// by the first one that applies, falling through unchanged if none
+	// matches. When empty, the flat tokenSource/sourceType/targetType fields
+	// above are used as a single implicit rule, as before.
+	Rules []RuleConfig `yaml:"rules"`
+
+	// credentialsFile points at an htpasswd- or htdigest-formatted file used
+	// to authenticate Basic credentials before conversion happens. users is
+	// an inline, htpasswd-style alternative (or addition) to the file.
+	// Neither is required; when both are empty the middleware never gates
+	// on credentials, as before.
+	credentialsFile string           `yaml:"credentialsFile"`
+	users           []UserCredential `yaml:"users"`
+}
+
+// UserCredential is one inline htpasswd-style entry: a username and its
+// already-hashed password (APR1-MD5, bcrypt or {SHA}).
+type UserCredential struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// RuleConfig is one entry of a Config.Rules pool: it behaves like a Config
+// for conversion purposes, plus matchers deciding whether it applies to a
+// given request.
+type RuleConfig struct {
+	TokenSource TokenSource `yaml:"tokenSource"`
+	EncodeToken bool        `yaml:"encodeToken"`
+	SourceType  AuthType    `yaml:"sourceType"`
+	TargetType  AuthType    `yaml:"targetType"`
+
+	// ClaimPath is the per-rule equivalent of Config.claimPath, read when
+	// TokenSource is jwt_claim so two rules can pull different claims out
+	// of the same or different tokens.
+	ClaimPath string `yaml:"claimPath"`
+
+	PathPrefix           string            `yaml:"pathPrefix"`
+	HostRegex            string            `yaml:"hostRegex"`
+	HeaderMatch          map[string]string `yaml:"headerMatch"`
+	SourceAuthTypePrefix string            `yaml:"sourceAuthTypePrefix"`
 }
 
 // Main struct
 type AuthConverter struct {
 	next   http.Handler
 	config *Config
+
+	httpClient *http.Client
+
+	forwardCacheMu sync.Mutex
+	forwardCache   map[string]forwardCacheEntry
+
+	jwksCacheMu sync.Mutex
+	jwksCache   *jwks
+
+	digestChallengeCacheMu sync.Mutex
+	digestChallengeCache   map[string]digestChallengeEntry
+
+	credentialsMu      sync.RWMutex
+	basicCredentials   map[string]string
+	digestCredentials  map[string]string
+	credentialsWatcher *fsnotify.Watcher
 }
 
 // Gets token from passed header
 func (e *AuthConverter) getToken(header string) (string, error) {
+	return e.extractToken(header, e.config.tokenSource, e.config.sourceType, e.config.claimPath)
+}
+
+// extractToken is the shared conversion logic behind getToken and rule
+// matching: it reads tokenSource/sourceType/claimPath as parameters rather
+// than off e.config so a single AuthConverter can apply different rules per
+// request.
+func (e *AuthConverter) extractToken(header string, tokenSource TokenSource, sourceType AuthType, claimPath string) (string, error) {
 	splitHeader := strings.SplitN(header, " ", 2)
 	if len(splitHeader) != 2 {
 		return "", errors.New("invalid authorization header contents")
 	}
-	if splitHeader[0] != string(e.config.sourceType) {
+	if splitHeader[0] != string(sourceType) {
 		return "", errors.New("invalid authorization type")
 	}
 	sourceTokenBase64 := splitHeader[1]
 
-	if e.config.tokenSource == unchanged {
+	if tokenSource == unchanged {
 		return sourceTokenBase64, nil
 	}
 
+	if tokenSource == jwtClaim || tokenSource == jwtSubject {
+		return e.getJWTClaim(sourceTokenBase64, tokenSource, claimPath)
+	}
+
 	sourceTokenDecoded, err := base64.StdEncoding.DecodeString(sourceTokenBase64)
 	if err != nil {
 		return "", errors.New("Base64 decoding failed")
 	}
 
-	if e.config.tokenSource == decoded {
+	if tokenSource == decoded {
 		return string(sourceTokenDecoded), nil
 	}
 
-	if e.config.sourceType != basic {
+	if sourceType != basic {
 		return "", errors.New("partial ")
 	}
 	basicTokenParts := strings.SplitN(string(sourceTokenDecoded), ":", 2)
@@ -75,7 +181,7 @@ func (e *AuthConverter) getToken(header string) (string, error) {
 		return "", errors.New("invalid value in authorization header")
 	}
 
-	switch e.config.tokenSource {
+	switch tokenSource {
 	case username:
 		return basicTokenParts[0], nil
 	case password:
@@ -89,6 +195,37 @@ func (e *AuthConverter) getToken(header string) (string, error) {
 
 // Entry point from Traefik
 func (e *AuthConverter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if e.config.credentialsFile != "" || len(e.config.users) > 0 {
+		if err := e.authenticateBasic(req.Header.Get(HeaderName)); err != nil {
+			http.Error(rw, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if len(e.config.Rules) > 0 {
+		e.serveWithRules(rw, req)
+		return
+	}
+
+	if e.config.forwardAddress != "" {
+		token, ok := e.exchangeForwardToken(rw, req)
+		if !ok {
+			// Response already written (propagated error or denial).
+			return
+		}
+		req.Header.Set(HeaderName, string(e.config.targetType)+" "+token)
+		e.next.ServeHTTP(rw, req)
+		return
+	}
+
+	if e.config.targetType == digest {
+		if authorization, err := e.buildDigestAuthorization(req, e.config.tokenSource); err == nil {
+			req.Header.Set(HeaderName, authorization)
+		}
+		e.next.ServeHTTP(rw, req)
+		return
+	}
+
 	token, err := e.getToken(req.Header.Get(HeaderName))
 	if err == nil {
 		if e.config.encodeToken {
@@ -111,13 +248,32 @@ func CreateConfig() *Config {
 }
 
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	validConfig := map[TokenSource]bool{username: true, password: true, unchanged: true, combined: true, decoded: true}
-	if !validConfig[config.tokenSource] {
+	validConfig := map[TokenSource]bool{username: true, password: true, unchanged: true, combined: true, decoded: true, jwtClaim: true, jwtSubject: true, basicPair: true}
+	if config.forwardAddress == "" && len(config.Rules) == 0 && !validConfig[config.tokenSource] {
 		return nil, errors.New("invalid token source")
 	}
 
-	return &AuthConverter{
+	converter := &AuthConverter{
 		next:   next,
 		config: config,
-	}, nil
+
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: config.forwardInsecureSkipVerify},
+			},
+		},
+		forwardCache:         make(map[string]forwardCacheEntry),
+		digestChallengeCache: make(map[string]digestChallengeEntry),
+	}
+
+	if config.credentialsFile != "" || len(config.users) > 0 {
+		if err := converter.loadCredentials(); err != nil {
+			return nil, err
+		}
+		if config.credentialsFile != "" {
+			converter.watchCredentialsFile()
+		}
+	}
+
+	return converter, nil
 }
@@ -0,0 +1,158 @@
+package traefik_auth_converter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// forwardCacheEntry holds a previously exchanged token so repeat requests
+// with the same source credentials don't hit the IdP on every call.
+type forwardCacheEntry struct {
+	token     string
+	expiresAt time.Time
+}
+
+// exchangeForwardToken calls the configured forward-auth endpoint with the
+// incoming credentials and returns the token it should be swapped for. It
+// returns ok == false once it has written a response itself (either because
+// the IdP denied the request or because the exchange failed), in which case
+// the caller must not continue to e.next.
+func (e *AuthConverter) exchangeForwardToken(rw http.ResponseWriter, req *http.Request) (string, bool) {
+	source := req.Header.Get(HeaderName)
+	if source == "" {
+		http.Error(rw, "missing authorization header", http.StatusUnauthorized)
+		return "", false
+	}
+
+	cacheKey := hashSourceHeader(source)
+	if token, ok := e.lookupForwardCache(cacheKey); ok {
+		return token, true
+	}
+
+	ctx := req.Context()
+	if e.config.forwardTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.config.forwardTimeout)
+		defer cancel()
+	}
+
+	forwardReq, err := http.NewRequestWithContext(ctx, http.MethodGet, e.config.forwardAddress, nil)
+	if err != nil {
+		http.Error(rw, "forward auth request could not be built", http.StatusInternalServerError)
+		return "", false
+	}
+	forwardReq.Header.Set(HeaderName, source)
+	if e.config.forwardForwardHeaders {
+		for name, values := range req.Header {
+			if name == HeaderName {
+				continue
+			}
+			forwardReq.Header[name] = values
+		}
+	}
+
+	resp, err := e.httpClient.Do(forwardReq)
+	if err != nil {
+		http.Error(rw, "forward auth endpoint unreachable", http.StatusBadGateway)
+		return "", false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		copyForwardResponse(rw, resp)
+		return "", false
+	}
+	if resp.StatusCode != http.StatusOK {
+		http.Error(rw, "forward auth endpoint returned an unexpected status", http.StatusBadGateway)
+		return "", false
+	}
+
+	token, err := e.extractForwardToken(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return "", false
+	}
+
+	e.storeForwardCache(cacheKey, token)
+	return token, true
+}
+
+// extractForwardToken pulls the issued token either from a configured
+// response header or from a JSON body field addressed by forwardResponseJSONPath.
+func (e *AuthConverter) extractForwardToken(resp *http.Response) (string, error) {
+	if e.config.forwardResponseHeader != "" {
+		token := resp.Header.Get(e.config.forwardResponseHeader)
+		if token == "" {
+			return "", fmt.Errorf("forward auth response missing header %q", e.config.forwardResponseHeader)
+		}
+		return token, nil
+	}
+
+	if e.config.forwardResponseJSONPath == "" {
+		return "", fmt.Errorf("neither forwardResponseHeader nor forwardResponseJSONPath is configured")
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("forward auth response body could not be read")
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return "", fmt.Errorf("forward auth response body is not valid JSON")
+	}
+
+	return lookupJSONPath(decoded, e.config.forwardResponseJSONPath)
+}
+
+// copyForwardResponse mirrors the IdP's denial back to the original client
+// verbatim, headers, status code and body alike.
+func copyForwardResponse(rw http.ResponseWriter, resp *http.Response) {
+	for name, values := range resp.Header {
+		for _, value := range values {
+			rw.Header().Add(name, value)
+		}
+	}
+	rw.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(rw, resp.Body)
+}
+
+func (e *AuthConverter) lookupForwardCache(key string) (string, bool) {
+	if e.config.forwardCacheTTL <= 0 {
+		return "", false
+	}
+
+	e.forwardCacheMu.Lock()
+	defer e.forwardCacheMu.Unlock()
+
+	entry, ok := e.forwardCache[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false
+	}
+	return entry.token, true
+}
+
+func (e *AuthConverter) storeForwardCache(key string, token string) {
+	if e.config.forwardCacheTTL <= 0 {
+		return
+	}
+
+	e.forwardCacheMu.Lock()
+	defer e.forwardCacheMu.Unlock()
+
+	e.forwardCache[key] = forwardCacheEntry{
+		token:     token,
+		expiresAt: time.Now().Add(e.config.forwardCacheTTL),
+	}
+}
+
+func hashSourceHeader(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
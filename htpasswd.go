@@ -0,0 +1,263 @@
+package traefik_auth_converter
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// authenticateBasic verifies the Basic credentials on header against the
+// loaded htpasswd/htdigest entries, returning an error if they are missing,
+// malformed, or don't match any known user.
+func (e *AuthConverter) authenticateBasic(header string) error {
+	splitHeader := strings.SplitN(header, " ", 2)
+	if len(splitHeader) != 2 || splitHeader[0] != string(basic) {
+		return errors.New("missing basic credentials")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(splitHeader[1])
+	if err != nil {
+		return errors.New("invalid basic credentials")
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return errors.New("invalid basic credentials")
+	}
+	username, password := parts[0], parts[1]
+
+	e.credentialsMu.RLock()
+	defer e.credentialsMu.RUnlock()
+
+	if hash, ok := e.basicCredentials[username]; ok && verifyHtpasswdHash(password, hash) {
+		return nil
+	}
+
+	for key, ha1 := range e.digestCredentials {
+		user, realm, ok := strings.Cut(key, ":")
+		if !ok || user != username {
+			continue
+		}
+		if md5Hex(fmt.Sprintf("%s:%s:%s", username, realm, password)) == ha1 {
+			return nil
+		}
+	}
+
+	return errors.New("invalid credentials")
+}
+
+// verifyHtpasswdHash checks password against an htpasswd-formatted hash,
+// supporting APR1-MD5, bcrypt and {SHA}. Plain crypt(3) DES hashes are
+// rejected by isSupportedHtpasswdHash at load time, so the default case
+// here is unreachable in practice; it exists as a safe fallback.
+func verifyHtpasswdHash(password, hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"):
+		return apr1MD5(password, apr1Salt(hash)) == hash
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return hash == "{SHA}"+base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		return false
+	}
+}
+
+// isSupportedHtpasswdHash reports whether hash is in one of the formats
+// verifyHtpasswdHash knows how to check. Traditional crypt(3) DES hashes
+// (htpasswd's original default, a 13-character string with no "$" prefix)
+// are not implemented yet and are rejected here so a misconfigured or
+// unrecognized entry fails loudly at load time instead of permanently and
+// silently locking that user out.
+func isSupportedHtpasswdHash(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"):
+		return true
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return true
+	case strings.HasPrefix(hash, "{SHA}"):
+		return true
+	default:
+		return false
+	}
+}
+
+func apr1Salt(hash string) string {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 4 {
+		return ""
+	}
+	return fields[2]
+}
+
+// apr1MD5 implements Apache's variant of the MD5-crypt algorithm used by
+// htpasswd -m, producing a full "$apr1$salt$digest" string.
+func apr1MD5(password, salt string) string {
+	const magic = "$apr1$"
+
+	ctx := md5.New()
+	ctx.Write([]byte(password))
+	ctx.Write([]byte(magic))
+	ctx.Write([]byte(salt))
+
+	altCtx := md5.New()
+	altCtx.Write([]byte(password))
+	altCtx.Write([]byte(salt))
+	altCtx.Write([]byte(password))
+	alt := altCtx.Sum(nil)
+
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(alt)
+		} else {
+			ctx.Write(alt[:i])
+		}
+	}
+
+	for i := len(password); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write([]byte(password[:1]))
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write([]byte(password))
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write([]byte(password))
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write([]byte(password))
+		}
+		final = round.Sum(nil)
+	}
+
+	return magic + salt + "$" + apr1Encode(final)
+}
+
+// apr1Encode reorders and base64-like-encodes the final MD5 digest using
+// APR1's own alphabet, per the algorithm's reference implementation.
+func apr1Encode(final []byte) string {
+	groups := [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}}
+	var out strings.Builder
+	for _, g := range groups {
+		value := uint32(final[g[0]])<<16 | uint32(final[g[1]])<<8 | uint32(final[g[2]])
+		for i := 0; i < 4; i++ {
+			out.WriteByte(apr1Alphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+	value := uint32(final[11])
+	for i := 0; i < 2; i++ {
+		out.WriteByte(apr1Alphabet[value&0x3f])
+		value >>= 6
+	}
+	return out.String()
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadCredentials (re)reads credentialsFile and config.users into fresh
+// lookup maps and swaps them in atomically.
+func (e *AuthConverter) loadCredentials() error {
+	basicCreds := map[string]string{}
+	digestCreds := map[string]string{}
+
+	if e.config.credentialsFile != "" {
+		contents, err := os.ReadFile(e.config.credentialsFile)
+		if err != nil {
+			return fmt.Errorf("credentials file could not be read: %w", err)
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.SplitN(line, ":", 3)
+			switch len(fields) {
+			case 2:
+				if !isSupportedHtpasswdHash(fields[1]) {
+					return fmt.Errorf("credentials file: unsupported password hash format for user %q", fields[0])
+				}
+				basicCreds[fields[0]] = fields[1]
+			case 3:
+				digestCreds[fields[0]+":"+fields[1]] = fields[2]
+			}
+		}
+	}
+
+	for _, user := range e.config.users {
+		if !isSupportedHtpasswdHash(user.Password) {
+			return fmt.Errorf("users: unsupported password hash format for user %q", user.Username)
+		}
+		basicCreds[user.Username] = user.Password
+	}
+
+	e.credentialsMu.Lock()
+	e.basicCredentials = basicCreds
+	e.digestCredentials = digestCreds
+	e.credentialsMu.Unlock()
+
+	return nil
+}
+
+// watchCredentialsFile reloads the credentials whenever credentialsFile
+// changes on disk, so operators can rotate them without restarting Traefik.
+//
+// It watches the containing directory rather than the file itself: an
+// atomic rotation (write a temp file, rename it over the target) unlinks
+// the watched inode and surfaces as Create/Rename/Remove/Chmod on the
+// directory, not Write on the file, and a direct file watch would never
+// fire again after the first rotation.
+func (e *AuthConverter) watchCredentialsFile() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	if err := watcher.Add(filepath.Dir(e.config.credentialsFile)); err != nil {
+		_ = watcher.Close()
+		return
+	}
+	e.credentialsWatcher = watcher
+
+	target := filepath.Base(e.config.credentialsFile)
+
+	go func() {
+		for event := range watcher.Events {
+			if filepath.Base(event.Name) != target {
+				continue
+			}
+			if err := e.loadCredentials(); err != nil {
+				log.Printf("traefik_auth_converter: credentials reload failed: %s", err)
+			}
+		}
+	}()
+}
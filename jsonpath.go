@@ -0,0 +1,47 @@
+package traefik_auth_converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupJSONPath resolves a dotted path (e.g. "resource_access.myapp.roles.0")
+// against decoded JSON data, descending through maps by key and through
+// slices by numeric index. The resolved leaf is stringified.
+func lookupJSONPath(data interface{}, path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("json path must not be empty")
+	}
+
+	current := data
+	for _, segment := range strings.Split(path, ".") {
+		switch typed := current.(type) {
+		case map[string]interface{}:
+			value, ok := typed[segment]
+			if !ok {
+				return "", fmt.Errorf("json path segment %q not found", segment)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(typed) {
+				return "", fmt.Errorf("json path segment %q is not a valid array index", segment)
+			}
+			current = typed[index]
+		default:
+			return "", fmt.Errorf("json path segment %q has no children", segment)
+		}
+	}
+
+	switch value := current.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		return "", fmt.Errorf("json path resolved to unsupported type %T", current)
+	}
+}
@@ -0,0 +1,263 @@
+package traefik_auth_converter
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const subjectClaimPath = "sub"
+
+// jwks is a cached JSON Web Key Set, refreshed at most once per jwksCacheTTL.
+type jwks struct {
+	fetchedAt time.Time
+	keys      map[string]jwk
+}
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Curve     string `json:"crv"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	K         string `json:"k"`
+}
+
+// getJWTClaim treats the raw Bearer token as a JWT, optionally verifies its
+// signature against the configured JWKS, and returns the claim addressed by
+// claimPath (or the standard "sub" claim for jwtSubject).
+func (e *AuthConverter) getJWTClaim(rawToken string, tokenSource TokenSource, claimPath string) (string, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("value is not a JWT")
+	}
+
+	payload, err := decodeJWTSegment(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("jwt payload could not be decoded: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("jwt payload is not valid JSON")
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(time.Now()) {
+		return "", errors.New("jwt has expired")
+	}
+
+	if e.config.jwksURL != "" {
+		if err := e.verifyJWTSignature(parts); err != nil {
+			return "", err
+		}
+	}
+
+	path := claimPath
+	if tokenSource == jwtSubject {
+		path = subjectClaimPath
+	}
+
+	return lookupJSONPath(claims, path)
+}
+
+func decodeJWTSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(segment)
+}
+
+// verifyJWTSignature checks the JWT's signature against a key from the
+// configured JWKS matching its "kid" header, supporting RS256, ES256 and HS256.
+func (e *AuthConverter) verifyJWTSignature(parts []string) error {
+	header, err := decodeJWTSegment(parts[0])
+	if err != nil {
+		return fmt.Errorf("jwt header could not be decoded: %w", err)
+	}
+
+	var decodedHeader struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &decodedHeader); err != nil {
+		return errors.New("jwt header is not valid JSON")
+	}
+
+	key, err := e.lookupJWK(decodedHeader.KeyID)
+	if err != nil {
+		return err
+	}
+
+	signature, err := decodeJWTSegment(parts[2])
+	if err != nil {
+		return fmt.Errorf("jwt signature could not be decoded: %w", err)
+	}
+	signedContent := parts[0] + "." + parts[1]
+
+	switch decodedHeader.Algorithm {
+	case "RS256":
+		return verifyRS256(key, signedContent, signature)
+	case "ES256":
+		return verifyES256(key, signedContent, signature)
+	case "HS256":
+		return verifyHS256(key, signedContent, signature)
+	default:
+		return fmt.Errorf("unsupported jwt signing algorithm %q", decodedHeader.Algorithm)
+	}
+}
+
+func verifyRS256(key jwk, signedContent string, signature []byte) error {
+	publicKey, err := key.rsaPublicKey()
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(signedContent))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return errors.New("jwt signature verification failed")
+	}
+	return nil
+}
+
+func verifyES256(key jwk, signedContent string, signature []byte) error {
+	publicKey, err := key.ecdsaPublicKey()
+	if err != nil {
+		return err
+	}
+	if len(signature) != 64 {
+		return errors.New("jwt signature has an unexpected length for ES256")
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	digest := sha256.Sum256([]byte(signedContent))
+	if !ecdsa.Verify(publicKey, digest[:], r, s) {
+		return errors.New("jwt signature verification failed")
+	}
+	return nil
+}
+
+func verifyHS256(key jwk, signedContent string, signature []byte) error {
+	secret, err := base64.RawURLEncoding.DecodeString(key.K)
+	if err != nil {
+		return fmt.Errorf("jwk secret could not be decoded: %w", err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signedContent))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return errors.New("jwt signature verification failed")
+	}
+	return nil
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("jwk modulus could not be decoded: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("jwk exponent could not be decoded: %w", err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func (k jwk) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("jwk x coordinate could not be decoded: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("jwk y coordinate could not be decoded: %w", err)
+	}
+	curve, err := ellipticCurve(k.Curve)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
+
+func ellipticCurve(name string) (elliptic.Curve, error) {
+	switch name {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported jwk curve %q", name)
+	}
+}
+
+// lookupJWK returns the JWKS entry matching kid, fetching and caching the set
+// from e.config.jwksURL if it is stale or not yet loaded.
+func (e *AuthConverter) lookupJWK(kid string) (jwk, error) {
+	e.jwksCacheMu.Lock()
+	defer e.jwksCacheMu.Unlock()
+
+	if e.jwksCache == nil || time.Since(e.jwksCache.fetchedAt) > e.jwksCacheTTLOrDefault() {
+		set, err := e.fetchJWKS()
+		if err != nil {
+			return jwk{}, err
+		}
+		e.jwksCache = set
+	}
+
+	key, ok := e.jwksCache.keys[kid]
+	if !ok {
+		return jwk{}, fmt.Errorf("no jwk found for kid %q", kid)
+	}
+	return key, nil
+}
+
+func (e *AuthConverter) jwksCacheTTLOrDefault() time.Duration {
+	if e.config.jwksCacheTTL > 0 {
+		return e.config.jwksCacheTTL
+	}
+	return 5 * time.Minute
+}
+
+func (e *AuthConverter) fetchJWKS() (*jwks, error) {
+	resp, err := e.httpClient.Get(e.config.jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("jwks could not be fetched: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("jwks response body could not be read")
+	}
+
+	var decoded struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("jwks response is not valid JSON")
+	}
+
+	keys := make(map[string]jwk, len(decoded.Keys))
+	for _, key := range decoded.Keys {
+		keys[key.KeyID] = key
+	}
+
+	return &jwks{fetchedAt: time.Now(), keys: keys}, nil
+}
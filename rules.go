@@ -0,0 +1,64 @@
+package traefik_auth_converter
+
+import (
+	"encoding/base64"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// serveWithRules applies the first matching rule in e.config.Rules and
+// forwards the (possibly converted) request, leaving it unchanged if no
+// rule matches.
+func (e *AuthConverter) serveWithRules(rw http.ResponseWriter, req *http.Request) {
+	for _, rule := range e.config.Rules {
+		if !ruleMatches(rule, req) {
+			continue
+		}
+
+		if rule.TargetType == digest {
+			if authorization, err := e.buildDigestAuthorization(req, rule.TokenSource); err == nil {
+				req.Header.Set(HeaderName, authorization)
+			}
+			break
+		}
+
+		token, err := e.extractToken(req.Header.Get(HeaderName), rule.TokenSource, rule.SourceType, rule.ClaimPath)
+		if err == nil {
+			if rule.EncodeToken {
+				token = base64.StdEncoding.EncodeToString([]byte(token))
+			}
+			req.Header.Set(HeaderName, string(rule.TargetType)+" "+token)
+		}
+		break
+	}
+
+	e.next.ServeHTTP(rw, req)
+}
+
+// ruleMatches reports whether every matcher configured on rule is satisfied
+// by req. A matcher left at its zero value is ignored.
+func ruleMatches(rule RuleConfig, req *http.Request) bool {
+	if rule.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, rule.PathPrefix) {
+		return false
+	}
+
+	if rule.HostRegex != "" {
+		matched, err := regexp.MatchString(rule.HostRegex, req.Host)
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	for name, value := range rule.HeaderMatch {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+
+	if rule.SourceAuthTypePrefix != "" && !strings.HasPrefix(req.Header.Get(HeaderName), rule.SourceAuthTypePrefix) {
+		return false
+	}
+
+	return true
+}